@@ -0,0 +1,52 @@
+/*
+Copyright 2019-2020 vChain, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gw
+
+import (
+	"context"
+	"time"
+)
+
+// MemberAuditFunc audits a single resolved immudb member, authenticating
+// with the given AuditorUsername/AuditorPassword credentials.
+type MemberAuditFunc func(ctx context.Context, member Endpoint, username string, password string) error
+
+// RunAuditor resolves r on every o.AuditorInterval tick and calls auditFn
+// once per resolved member, in turn, so a multi-node cluster gets every
+// replica checked instead of only the first. It blocks until ctx is done.
+func RunAuditor(ctx context.Context, o Options, r Resolver, auditFn MemberAuditFunc) error {
+	ticker := time.NewTicker(o.AuditorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			members, err := r.Resolve(ctx)
+			if err != nil {
+				continue
+			}
+
+			for _, member := range members {
+				// One member failing to audit shouldn't stop the rest of the
+				// cluster from being checked on this tick.
+				_ = auditFn(ctx, member, o.AuditorUsername, o.AuditorPassword)
+			}
+		}
+	}
+}
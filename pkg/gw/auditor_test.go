@@ -0,0 +1,60 @@
+/*
+Copyright 2019-2020 vChain, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package gw
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Neither NewStaticResolver nor NewEtcdResolver nor NewGRPCResolverBuilder
+// was wired into anything that actually iterates cluster members for
+// auditing; RunAuditor now resolves r on every tick and visits every member
+// it returns, not just the first.
+func TestRunAuditorVisitsEveryMember(t *testing.T) {
+	r, err := NewStaticResolver([]string{"10.0.0.1:3322", "10.0.0.2:3322", "10.0.0.3:3322"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o := DefaultOptions().WithAuditorInterval(5 * time.Millisecond)
+
+	var mu sync.Mutex
+	visited := map[string]int{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+
+	err = RunAuditor(ctx, o, r, func(_ context.Context, member Endpoint, username, password string) error {
+		mu.Lock()
+		visited[member.String()]++
+		mu.Unlock()
+		return nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected RunAuditor to stop when ctx is done, got %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, hostPort := range []string{"10.0.0.1:3322", "10.0.0.2:3322", "10.0.0.3:3322"} {
+		if visited[hostPort] == 0 {
+			t.Errorf("expected member %s to have been audited", hostPort)
+		}
+	}
+}
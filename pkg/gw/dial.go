@@ -0,0 +1,51 @@
+/*
+Copyright 2019-2020 vChain, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gw
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc"
+)
+
+// roundRobinServiceConfig selects the round_robin balancer, so the
+// ClientConn actually spreads RPCs across every address the resolver
+// reports instead of pinning the first one it sees.
+const roundRobinServiceConfig = `{"loadBalancingConfig": [{"round_robin":{}}]}`
+
+// Dial opens the gateway's gRPC connection to immudb. With o.EtcdEndpoints or
+// o.ImmudbEndpoints set, it dials through the "immudb" resolver scheme backed
+// by the corresponding Resolver (etcd-backed or static), so the balancer
+// re-dials as that resolver's Watch reports membership changes; with neither
+// set, it dials the single ImmudbAddress/ImmudbPort pair directly.
+func Dial(o Options, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	dialOpts := append([]grpc.DialOption{grpc.WithDefaultServiceConfig(roundRobinServiceConfig)}, opts...)
+
+	r, err := NewResolver(o)
+	if err != nil {
+		return nil, err
+	}
+
+	if r == nil {
+		return grpc.Dial(fmt.Sprintf("%s:%d", o.ImmudbAddress, o.ImmudbPort), dialOpts...)
+	}
+
+	builder := NewGRPCResolverBuilder(r)
+	dialOpts = append(dialOpts, grpc.WithResolvers(builder))
+
+	return grpc.Dial(fmt.Sprintf("%s:///cluster", builder.Scheme()), dialOpts...)
+}
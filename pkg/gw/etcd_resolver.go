@@ -0,0 +1,149 @@
+/*
+Copyright 2019-2020 vChain, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gw
+
+import (
+	"context"
+	"sync"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// etcdResolver resolves immudb cluster membership from an etcd key prefix,
+// where each key under prefix holds one member's host:port value (e.g.
+// /immudb/servers/node1 -> "10.0.0.1:3322").
+type etcdResolver struct {
+	cli    *clientv3.Client
+	prefix string
+
+	mutex     sync.Mutex
+	endpoints map[string]Endpoint
+}
+
+// NewEtcdResolver returns a Resolver backed by an etcd watch over prefix.
+func NewEtcdResolver(cli *clientv3.Client, prefix string) Resolver {
+	return &etcdResolver{
+		cli:       cli,
+		prefix:    prefix,
+		endpoints: make(map[string]Endpoint),
+	}
+}
+
+func (r *etcdResolver) Resolve(ctx context.Context) ([]Endpoint, error) {
+	endpoints, _, err := r.resolveAt(ctx)
+	return endpoints, err
+}
+
+// resolveAt resolves the current endpoint set and the etcd revision it was
+// read at, so Watch can pin its watch there and not miss a change committed
+// between the initial Get and the watch stream actually starting.
+func (r *etcdResolver) resolveAt(ctx context.Context) ([]Endpoint, int64, error) {
+	resp, err := r.cli.Get(ctx, r.prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, 0, err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.endpoints = make(map[string]Endpoint, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		e, err := parseEndpoint(string(kv.Value))
+		if err != nil {
+			continue
+		}
+		r.endpoints[string(kv.Key)] = e
+	}
+
+	return r.snapshotLocked(), resp.Header.Revision, nil
+}
+
+func (r *etcdResolver) snapshotLocked() []Endpoint {
+	endpoints := make([]Endpoint, 0, len(r.endpoints))
+	for _, e := range r.endpoints {
+		endpoints = append(endpoints, e)
+	}
+	return endpoints
+}
+
+// Watch streams the full, up-to-date endpoint set on every membership
+// change observed under prefix, until ctx is done.
+func (r *etcdResolver) Watch(ctx context.Context) <-chan []Endpoint {
+	ch := make(chan []Endpoint, 1)
+
+	go func() {
+		defer close(ch)
+
+		initial, revision, err := r.resolveAt(ctx)
+		if err != nil {
+			return
+		}
+
+		select {
+		case ch <- initial:
+		case <-ctx.Done():
+			return
+		}
+
+		// Start the watch at revision+1 so a membership change committed
+		// between the Get above and this call isn't silently missed.
+		watchCh := r.cli.Watch(ctx, r.prefix, clientv3.WithPrefix(), clientv3.WithRev(revision+1))
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case resp, ok := <-watchCh:
+				if !ok {
+					return
+				}
+
+				r.applyEvents(resp.Events)
+
+				r.mutex.Lock()
+				endpoints := r.snapshotLocked()
+				r.mutex.Unlock()
+
+				select {
+				case ch <- endpoints:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return ch
+}
+
+func (r *etcdResolver) applyEvents(events []*clientv3.Event) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, ev := range events {
+		key := string(ev.Kv.Key)
+
+		if ev.Type == clientv3.EventTypeDelete {
+			delete(r.endpoints, key)
+			continue
+		}
+
+		if e, err := parseEndpoint(string(ev.Kv.Value)); err == nil {
+			r.endpoints[key] = e
+		}
+	}
+}
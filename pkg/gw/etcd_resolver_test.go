@@ -0,0 +1,123 @@
+/*
+Copyright 2019-2020 vChain, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package gw
+
+import (
+	"testing"
+
+	mvccpb "go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func putEvent(key, value string) *clientv3.Event {
+	return &clientv3.Event{
+		Type: clientv3.EventTypePut,
+		Kv:   &mvccpb.KeyValue{Key: []byte(key), Value: []byte(value)},
+	}
+}
+
+func deleteEvent(key string) *clientv3.Event {
+	return &clientv3.Event{
+		Type: clientv3.EventTypeDelete,
+		Kv:   &mvccpb.KeyValue{Key: []byte(key)},
+	}
+}
+
+// etcdResolver used to be unreachable from Options/Dial, so this is the
+// first coverage of its membership bookkeeping: applyEvents folding puts and
+// deletes into r.endpoints, keyed by the raw etcd key.
+func TestEtcdResolverAppliesPutAndDeleteEvents(t *testing.T) {
+	r := &etcdResolver{prefix: "/immudb/servers/", endpoints: make(map[string]Endpoint)}
+
+	r.applyEvents([]*clientv3.Event{
+		putEvent("/immudb/servers/node1", "10.0.0.1:3322"),
+		putEvent("/immudb/servers/node2", "10.0.0.2:3322"),
+	})
+
+	endpoints := r.snapshotLocked()
+	if len(endpoints) != 2 {
+		t.Fatalf("got %d endpoints, want 2", len(endpoints))
+	}
+
+	r.applyEvents([]*clientv3.Event{
+		deleteEvent("/immudb/servers/node1"),
+		putEvent("/immudb/servers/node2", "10.0.0.2:3323"),
+	})
+
+	endpoints = r.snapshotLocked()
+	if len(endpoints) != 1 {
+		t.Fatalf("got %d endpoints after delete, want 1", len(endpoints))
+	}
+	if endpoints[0].Port != 3323 {
+		t.Fatalf("got port %d, want put to have updated node2 to 3323", endpoints[0].Port)
+	}
+}
+
+// A malformed value (not host:port) must not poison the resolver's state:
+// the event is dropped rather than replacing a previously-known-good entry
+// with a zero-value Endpoint.
+func TestEtcdResolverIgnoresMalformedValue(t *testing.T) {
+	r := &etcdResolver{prefix: "/immudb/servers/", endpoints: make(map[string]Endpoint)}
+
+	r.applyEvents([]*clientv3.Event{putEvent("/immudb/servers/node1", "not-a-host-port")})
+
+	if len(r.snapshotLocked()) != 0 {
+		t.Fatal("expected a malformed value to be dropped, not stored")
+	}
+}
+
+// NewResolver is what makes etcdResolver reachable at all: Dial goes through
+// it instead of constructing a staticResolver directly, so EtcdEndpoints now
+// actually selects the etcd-backed path.
+func TestNewResolverPrefersEtcdOverStatic(t *testing.T) {
+	o := DefaultOptions().
+		WithImmudbEndpoints([]string{"10.0.0.1:3322"}).
+		WithEtcdEndpoints([]string{"127.0.0.1:2379"}).
+		WithEtcdKeyPrefix("/immudb/servers/")
+
+	r, err := NewResolver(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := r.(*etcdResolver); !ok {
+		t.Fatalf("got %T, want *etcdResolver when EtcdEndpoints is set", r)
+	}
+}
+
+func TestNewResolverFallsBackToStatic(t *testing.T) {
+	o := DefaultOptions().WithImmudbEndpoints([]string{"10.0.0.1:3322"})
+
+	r, err := NewResolver(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := r.(*staticResolver); !ok {
+		t.Fatalf("got %T, want *staticResolver", r)
+	}
+}
+
+func TestNewResolverNilWhenUnconfigured(t *testing.T) {
+	r, err := NewResolver(DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r != nil {
+		t.Fatalf("got %T, want nil resolver for single-node Options", r)
+	}
+}
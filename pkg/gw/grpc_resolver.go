@@ -0,0 +1,91 @@
+/*
+Copyright 2019-2020 vChain, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gw
+
+import (
+	"context"
+
+	"google.golang.org/grpc/resolver"
+)
+
+// grpcResolverScheme is the scheme immugw registers its Resolver under, so
+// that grpc.Dial("immudb:///cluster", ...) re-balances across whatever
+// Resolver.Watch reports instead of pinning a single address.
+const grpcResolverScheme = "immudb"
+
+// grpcResolverBuilder adapts a Resolver to grpc's resolver.Builder so it can
+// back a ClientConn's address list.
+type grpcResolverBuilder struct {
+	resolver Resolver
+}
+
+// NewGRPCResolverBuilder wraps r as a grpc resolver.Builder registered under
+// the "immudb" scheme.
+func NewGRPCResolverBuilder(r Resolver) resolver.Builder {
+	return &grpcResolverBuilder{resolver: r}
+}
+
+func (b *grpcResolverBuilder) Scheme() string {
+	return grpcResolverScheme
+}
+
+func (b *grpcResolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	r := &grpcResolverImpl{
+		resolver: b.resolver,
+		cc:       cc,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+
+	r.run()
+
+	return r, nil
+}
+
+// grpcResolverImpl pushes every Resolver.Watch update into the ClientConn so
+// the underlying balancer re-dials as immudb cluster membership changes.
+type grpcResolverImpl struct {
+	resolver Resolver
+	cc       resolver.ClientConn
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+func (r *grpcResolverImpl) run() {
+	updates := r.resolver.Watch(r.ctx)
+
+	go func() {
+		for endpoints := range updates {
+			addresses := make([]resolver.Address, len(endpoints))
+			for i, e := range endpoints {
+				addresses[i] = resolver.Address{Addr: e.String()}
+			}
+
+			r.cc.UpdateState(resolver.State{Addresses: addresses})
+		}
+	}()
+}
+
+// ResolveNow is a no-op: updates are pushed by the background Watch loop
+// rather than pulled on demand.
+func (r *grpcResolverImpl) ResolveNow(resolver.ResolveNowOptions) {}
+
+func (r *grpcResolverImpl) Close() {
+	r.cancel()
+}
@@ -25,12 +25,23 @@ import (
 )
 
 type Options struct {
-	Dir             string
-	Address         string
-	Port            int
-	MetricsPort     int
-	ImmudbAddress   string
-	ImmudbPort      int
+	Dir           string
+	Address       string
+	Port          int
+	MetricsPort   int
+	ImmudbAddress string
+	ImmudbPort    int
+	// ImmudbEndpoints, when set, supersedes ImmudbAddress/ImmudbPort with a
+	// cluster of immudb members (host:port) that the resolver fronts,
+	// letting the gateway fail over instead of depending on a single node.
+	ImmudbEndpoints []string
+	// EtcdEndpoints, when set, supersedes ImmudbEndpoints: the immudb cluster
+	// membership is discovered from an etcd watch over EtcdKeyPrefix instead
+	// of a fixed list, so nodes can join or leave without a gateway restart.
+	EtcdEndpoints []string
+	// EtcdKeyPrefix is the etcd key prefix the resolver watches when
+	// EtcdEndpoints is set; each key under it holds one member's host:port.
+	EtcdKeyPrefix   string
 	Auditor         bool
 	AuditorInterval time.Duration
 	AuditorUsername string
@@ -93,6 +104,28 @@ func (o Options) WithImmudbPort(immudbPort int) Options {
 	return o
 }
 
+// WithImmudbEndpoints sets the immudb cluster members (host:port) the
+// gateway's resolver discovers and load-balances across, in place of the
+// single ImmudbAddress/ImmudbPort pair.
+func (o Options) WithImmudbEndpoints(immudbEndpoints []string) Options {
+	o.ImmudbEndpoints = immudbEndpoints
+	return o
+}
+
+// WithEtcdEndpoints sets the etcd cluster (host:port) the resolver connects
+// to for membership discovery, in place of the static ImmudbEndpoints list.
+func (o Options) WithEtcdEndpoints(etcdEndpoints []string) Options {
+	o.EtcdEndpoints = etcdEndpoints
+	return o
+}
+
+// WithEtcdKeyPrefix sets the etcd key prefix the resolver watches for
+// immudb cluster membership.
+func (o Options) WithEtcdKeyPrefix(etcdKeyPrefix string) Options {
+	o.EtcdKeyPrefix = etcdKeyPrefix
+	return o
+}
+
 // WithAuditor sets Auditor
 func (o Options) WithAuditor(auditor bool) Options {
 	o.Auditor = auditor
@@ -162,8 +195,18 @@ func (o Options) MetricsBind() string {
 	return fmt.Sprintf("%s:%d", o.Address, o.MetricsPort)
 }
 
+// String renders Options as JSON for /debug introspection. It reflects only
+// the static configuration fields (ImmudbEndpoints, EtcdEndpoints, ...), not
+// the live endpoint set a Resolver built from them has actually resolved.
+// AuditorPassword is redacted so it never ends up in logs or debug
+// endpoints.
 func (o Options) String() string {
-	optionsJson, err := json.Marshal(o)
+	redacted := o
+	if redacted.AuditorPassword != "" {
+		redacted.AuditorPassword = "***"
+	}
+
+	optionsJson, err := json.Marshal(redacted)
 	if err != nil {
 		return err.Error()
 	}
@@ -0,0 +1,132 @@
+/*
+Copyright 2019-2020 vChain, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gw
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// Endpoint identifies one immudb cluster member.
+type Endpoint struct {
+	Address string
+	Port    int
+}
+
+func (e Endpoint) String() string {
+	return fmt.Sprintf("%s:%d", e.Address, e.Port)
+}
+
+func parseEndpoint(hostPort string) (Endpoint, error) {
+	idx := strings.LastIndex(hostPort, ":")
+	if idx < 0 {
+		return Endpoint{}, fmt.Errorf("invalid endpoint %q, expected host:port", hostPort)
+	}
+
+	port, err := strconv.Atoi(hostPort[idx+1:])
+	if err != nil {
+		return Endpoint{}, fmt.Errorf("invalid endpoint %q: %w", hostPort, err)
+	}
+
+	return Endpoint{Address: hostPort[:idx], Port: port}, nil
+}
+
+// Resolver discovers the set of immudb endpoints the gateway should
+// load-balance across.
+type Resolver interface {
+	// Resolve returns the currently known endpoints.
+	Resolve(ctx context.Context) ([]Endpoint, error)
+	// Watch returns a channel of endpoint sets, pushed whenever membership
+	// changes. The channel is closed when ctx is done or no further
+	// changes will ever be observed (e.g. a static resolver).
+	Watch(ctx context.Context) <-chan []Endpoint
+}
+
+// staticResolver always resolves to the fixed endpoint set it was built
+// with, used when Options.ImmudbEndpoints is set without an etcd backend.
+type staticResolver struct {
+	endpoints []Endpoint
+}
+
+// NewStaticResolver returns a Resolver over a fixed set of host:port
+// endpoints.
+func NewStaticResolver(hostPorts []string) (Resolver, error) {
+	endpoints := make([]Endpoint, len(hostPorts))
+
+	for i, hp := range hostPorts {
+		e, err := parseEndpoint(hp)
+		if err != nil {
+			return nil, err
+		}
+		endpoints[i] = e
+	}
+
+	return &staticResolver{endpoints: endpoints}, nil
+}
+
+// NewResolver builds the Resolver Dial uses to discover immudb cluster
+// membership from o (RunAuditor takes its Resolver as an argument and does
+// not call NewResolver itself): an etcd-backed resolver when EtcdEndpoints is
+// set, a static resolver over ImmudbEndpoints otherwise, or nil when neither
+// is configured, meaning the single ImmudbAddress/ImmudbPort pair should be
+// used directly.
+func NewResolver(o Options) (Resolver, error) {
+	if len(o.EtcdEndpoints) > 0 {
+		if o.EtcdKeyPrefix == "" {
+			return nil, fmt.Errorf("EtcdKeyPrefix must be set when EtcdEndpoints is configured")
+		}
+
+		cli, err := clientv3.New(clientv3.Config{Endpoints: o.EtcdEndpoints})
+		if err != nil {
+			return nil, err
+		}
+
+		return NewEtcdResolver(cli, o.EtcdKeyPrefix), nil
+	}
+
+	if len(o.ImmudbEndpoints) > 0 {
+		return NewStaticResolver(o.ImmudbEndpoints)
+	}
+
+	return nil, nil
+}
+
+func (r *staticResolver) Resolve(ctx context.Context) ([]Endpoint, error) {
+	return r.endpoints, nil
+}
+
+// Watch pushes the fixed endpoint set once, so a grpcResolverImpl built over
+// a staticResolver still calls UpdateState, then closes: membership never
+// changes again.
+func (r *staticResolver) Watch(ctx context.Context) <-chan []Endpoint {
+	ch := make(chan []Endpoint, 1)
+
+	go func() {
+		defer close(ch)
+
+		select {
+		case ch <- r.endpoints:
+		case <-ctx.Done():
+		}
+	}()
+
+	return ch
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2019-2020 vChain, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package gw
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+type fakeClientConn struct {
+	mu     sync.Mutex
+	states []resolver.State
+}
+
+func (f *fakeClientConn) UpdateState(s resolver.State) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.states = append(f.states, s)
+	return nil
+}
+
+func (f *fakeClientConn) ReportError(error)                                     {}
+func (f *fakeClientConn) NewAddress(addresses []resolver.Address)               {}
+func (f *fakeClientConn) NewServiceConfig(serviceConfig string)                  {}
+func (f *fakeClientConn) ParseServiceConfig(s string) *serviceconfig.ParseResult { return nil }
+
+func (f *fakeClientConn) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.states)
+}
+
+// staticResolver.Watch used to close its channel without ever sending, so a
+// grpcResolverImpl built over it never called UpdateState and the ClientConn
+// never learned the static endpoint set.
+func TestStaticResolverPushesInitialState(t *testing.T) {
+	r, err := NewStaticResolver([]string{"10.0.0.1:3322", "10.0.0.2:3322"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	builder := NewGRPCResolverBuilder(r)
+	cc := &fakeClientConn{}
+
+	grpcResolver, err := builder.Build(resolver.Target{}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer grpcResolver.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for cc.count() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if cc.count() == 0 {
+		t.Fatal("expected UpdateState to be called at least once for a static resolver")
+	}
+}
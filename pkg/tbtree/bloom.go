@@ -0,0 +1,151 @@
+/*
+Copyright 2019-2020 vChain, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package tbtree
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+// DefaultBloomFPRate is the false-positive rate used to size a snapshot's
+// bloom filter when Options.WithBloomFPRate is not set.
+const DefaultBloomFPRate = 0.01
+
+// bloomFilter is a bloom filter over a single bit array of m bits, addressed
+// with k hash functions derived, via double hashing, from two 64-bit fnv
+// hashes of the key. Every probe ranges over the whole array: the array is
+// not split into per-hash partitions.
+type bloomFilter struct {
+	bits []uint64
+	m    uint64
+	k    int
+}
+
+func newBloomFilter(expectedInsertions uint64, fpRate float64, pool *sync.Pool) *bloomFilter {
+	if fpRate <= 0 || fpRate >= 1 {
+		fpRate = DefaultBloomFPRate
+	}
+
+	m := bloomOptimalM(expectedInsertions, fpRate)
+	k := bloomOptimalK(expectedInsertions, m)
+	words := (m + 63) / 64
+
+	var bits []uint64
+	if pool != nil {
+		if pooled, ok := pool.Get().([]uint64); ok && pooled != nil {
+			bits = pooled[:0]
+		}
+	}
+	if uint64(cap(bits)) < words {
+		bits = make([]uint64, words)
+	} else {
+		bits = bits[:words]
+		for i := range bits {
+			bits[i] = 0
+		}
+	}
+
+	return &bloomFilter{bits: bits, m: words * 64, k: k}
+}
+
+func bloomOptimalM(n uint64, p float64) uint64 {
+	if n == 0 {
+		n = 1
+	}
+
+	m := math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2))
+	if m < 64 {
+		m = 64
+	}
+
+	return uint64(m)
+}
+
+func bloomOptimalK(n uint64, m uint64) int {
+	if n == 0 {
+		return 1
+	}
+
+	k := int(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+
+	return k
+}
+
+func bloomHashes(key []byte) (h1 uint64, h2 uint64) {
+	ha := fnv.New64a()
+	ha.Write(key)
+	h1 = ha.Sum64()
+
+	h := fnv.New64()
+	h.Write(key)
+	h2 = h.Sum64()
+
+	if h2 == 0 {
+		h2 = 1
+	}
+
+	return h1, h2
+}
+
+func (bf *bloomFilter) add(key []byte) {
+	h1, h2 := bloomHashes(key)
+
+	for i := 0; i < bf.k; i++ {
+		idx := (h1 + uint64(i)*h2) % bf.m
+		bf.bits[idx/64] |= 1 << (idx % 64)
+	}
+}
+
+func (bf *bloomFilter) mayContain(key []byte) bool {
+	h1, h2 := bloomHashes(key)
+
+	for i := 0; i < bf.k; i++ {
+		idx := (h1 + uint64(i)*h2) % bf.m
+		if bf.bits[idx/64]&(1<<(idx%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// union ORs every bit set in other into bf, used to carry a prior snapshot's
+// filter forward into the next one so keys inserted before it are still
+// found. Both filters must have been sized identically (same expected
+// insertions and fpRate); a mismatched other is ignored.
+func (bf *bloomFilter) union(other *bloomFilter) {
+	if other == nil || len(other.bits) != len(bf.bits) {
+		return
+	}
+
+	for i := range bf.bits {
+		bf.bits[i] |= other.bits[i]
+	}
+}
+
+// recycle returns the filter's bit array to pool so the next snapshot's
+// filter can reuse the backing storage instead of allocating.
+func (bf *bloomFilter) recycle(pool *sync.Pool) {
+	if bf == nil || pool == nil {
+		return
+	}
+
+	pool.Put(bf.bits)
+}
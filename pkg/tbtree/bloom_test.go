@@ -0,0 +1,64 @@
+/*
+Copyright 2019-2020 vChain, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package tbtree
+
+import "testing"
+
+// newSnapshot used to reset t.bloom to an empty filter on every snapshot
+// instead of carrying the previous one forward, so Get on a key inserted
+// before the most recent snapshot short-circuited to ErrKeyNotFound even
+// though the key was still reachable from the root.
+func TestSnapshotBloomFilterAccumulatesAcrossSnapshots(t *testing.T) {
+	tree, err := NewWith(DefaultOptions().setInsertionCountThreshold(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	if err := tree.Insert([]byte("k1"), []byte("v1"), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	snap1, err := tree.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := snap1.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tree.Insert([]byte("k2"), []byte("v2"), 2); err != nil {
+		t.Fatal(err)
+	}
+
+	snap2, err := tree.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap2.Close()
+
+	if value, _, err := snap2.Get([]byte("k1")); err != nil {
+		t.Fatalf("expected k1 inserted before the latest snapshot to still be found, got err=%v", err)
+	} else if string(value) != "v1" {
+		t.Fatalf("got %q, want v1", value)
+	}
+
+	if value, _, err := snap2.Get([]byte("k2")); err != nil {
+		t.Fatalf("expected k2 to be found: %v", err)
+	} else if string(value) != "v2" {
+		t.Fatalf("got %q, want v2", value)
+	}
+}
@@ -0,0 +1,265 @@
+/*
+Copyright 2019-2020 vChain, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package tbtree
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultCacheBytes is the node manager's cache budget used when
+// Options.WithCacheBytes is not set.
+const DefaultCacheBytes = 1 << 26 // 64Mb
+
+const nodeManagerShards = 16
+
+// NodeManager loads nodes that have been flushed to the append-only log,
+// caching them in memory up to a configured byte budget so persistence
+// doesn't require re-reading from disk on every access, while still keeping
+// memory use bounded.
+type NodeManager interface {
+	Load(offset uint64) (node, error)
+	Pin(n node)
+	Unpin(n node)
+	Evict()
+	Stats() CacheStats
+}
+
+// CacheStats reports NodeManager usage, useful for operators sizing the
+// cache.
+type CacheStats struct {
+	Hits       uint64
+	Misses     uint64
+	Evictions  uint64
+	BytesInUse int64
+}
+
+type cacheEntry struct {
+	offset uint64
+	node   node
+	size   int64
+	pinned int
+}
+
+type lruShard struct {
+	mu    sync.Mutex
+	items map[uint64]*list.Element
+	order *list.List
+	bytes int64
+}
+
+// lruNodeManager is the default NodeManager: a sharded LRU, each shard
+// independently bounded to maxBytes/shards so a single hot shard can't starve
+// the others of their budget.
+type lruNodeManager struct {
+	loader    func(offset uint64) (node, error)
+	shards    []*lruShard
+	maxBytes  int64
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+func newLRUNodeManager(maxBytes int64, loader func(offset uint64) (node, error)) *lruNodeManager {
+	if maxBytes <= 0 {
+		maxBytes = DefaultCacheBytes
+	}
+
+	shards := make([]*lruShard, nodeManagerShards)
+	for i := range shards {
+		shards[i] = &lruShard{items: make(map[uint64]*list.Element), order: list.New()}
+	}
+
+	return &lruNodeManager{loader: loader, shards: shards, maxBytes: maxBytes}
+}
+
+func (m *lruNodeManager) shardFor(offset uint64) *lruShard {
+	return m.shards[offset%uint64(len(m.shards))]
+}
+
+func (m *lruNodeManager) Load(offset uint64) (node, error) {
+	shard := m.shardFor(offset)
+
+	shard.mu.Lock()
+	if el, ok := shard.items[offset]; ok {
+		shard.order.MoveToFront(el)
+		entry := el.Value.(*cacheEntry)
+		shard.mu.Unlock()
+
+		atomic.AddUint64(&m.hits, 1)
+
+		return entry.node, nil
+	}
+	shard.mu.Unlock()
+
+	atomic.AddUint64(&m.misses, 1)
+
+	n, err := m.loader(offset)
+	if err != nil {
+		return nil, err
+	}
+
+	m.insert(shard, offset, n)
+
+	return n, nil
+}
+
+func (m *lruNodeManager) insert(shard *lruShard, offset uint64, n node) {
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if _, ok := shard.items[offset]; ok {
+		return
+	}
+
+	entry := &cacheEntry{offset: offset, node: n, size: nodeSizeOf(n)}
+	shard.items[offset] = shard.order.PushFront(entry)
+	shard.bytes += entry.size
+
+	m.evictShardLocked(shard)
+}
+
+func (m *lruNodeManager) evictShardLocked(shard *lruShard) {
+	budget := m.maxBytes / int64(len(m.shards))
+
+	for el := shard.order.Back(); el != nil && shard.bytes > budget; {
+		prev := el.Prev()
+
+		entry := el.Value.(*cacheEntry)
+		if entry.pinned == 0 {
+			shard.order.Remove(el)
+			delete(shard.items, entry.offset)
+			shard.bytes -= entry.size
+
+			atomic.AddUint64(&m.evictions, 1)
+		}
+
+		el = prev
+	}
+}
+
+// Pin protects n from eviction while a snapshot still references it. n may
+// not be in the cache yet — Snapshot() flushes the root and pins it in the
+// same call, before anything has ever Load()ed it back — so Pin inserts the
+// entry itself rather than assuming Load got there first.
+func (m *lruNodeManager) Pin(n node) {
+	offset, flushed := nodeOffsetOf(n)
+	if !flushed {
+		return
+	}
+
+	shard := m.shardFor(offset)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if el, ok := shard.items[offset]; ok {
+		el.Value.(*cacheEntry).pinned++
+		return
+	}
+
+	entry := &cacheEntry{offset: offset, node: n, size: nodeSizeOf(n), pinned: 1}
+	shard.items[offset] = shard.order.PushFront(entry)
+	shard.bytes += entry.size
+
+	m.evictShardLocked(shard)
+}
+
+func (m *lruNodeManager) Unpin(n node) {
+	offset, flushed := nodeOffsetOf(n)
+	if !flushed {
+		return
+	}
+
+	shard := m.shardFor(offset)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if el, ok := shard.items[offset]; ok {
+		entry := el.Value.(*cacheEntry)
+		if entry.pinned > 0 {
+			entry.pinned--
+		}
+	}
+}
+
+// Evict drops every unpinned entry, regardless of the configured budget.
+func (m *lruNodeManager) Evict() {
+	for _, shard := range m.shards {
+		shard.mu.Lock()
+
+		for el := shard.order.Back(); el != nil; {
+			prev := el.Prev()
+
+			entry := el.Value.(*cacheEntry)
+			if entry.pinned == 0 {
+				shard.order.Remove(el)
+				delete(shard.items, entry.offset)
+				shard.bytes -= entry.size
+
+				atomic.AddUint64(&m.evictions, 1)
+			}
+
+			el = prev
+		}
+
+		shard.mu.Unlock()
+	}
+}
+
+func (m *lruNodeManager) Stats() CacheStats {
+	var bytesInUse int64
+
+	for _, shard := range m.shards {
+		shard.mu.Lock()
+		bytesInUse += shard.bytes
+		shard.mu.Unlock()
+	}
+
+	return CacheStats{
+		Hits:       atomic.LoadUint64(&m.hits),
+		Misses:     atomic.LoadUint64(&m.misses),
+		Evictions:  atomic.LoadUint64(&m.evictions),
+		BytesInUse: bytesInUse,
+	}
+}
+
+// nodeOffsetOf reads a node's durable offset, if any. It takes the node's
+// flushMu because a concurrent flush of the same (possibly shared) node may
+// be writing these fields without the caller holding t.rwmutex.
+func nodeOffsetOf(n node) (offset uint64, flushed bool) {
+	switch v := n.(type) {
+	case *innerNode:
+		v.flushMu.Lock()
+		defer v.flushMu.Unlock()
+		return v.offset, v.flushed
+	case *leafNode:
+		v.flushMu.Lock()
+		defer v.flushMu.Unlock()
+		return v.offset, v.flushed
+	}
+	return 0, false
+}
+
+func nodeSizeOf(n node) int64 {
+	switch v := n.(type) {
+	case *innerNode:
+		return int64(len(encodeInnerNode(v)))
+	case *leafNode:
+		return int64(len(encodeLeafNode(v)))
+	}
+	return 0
+}
@@ -0,0 +1,376 @@
+/*
+Copyright 2019-2020 vChain, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package tbtree
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+)
+
+// segmentFileName is the single append-only segment every flush is written
+// to; there is no rolling, so it grows for the life of the store.
+const segmentFileName = "btree.0000000000.data"
+
+const (
+	recordTypeInnerNode byte = 1
+	recordTypeLeafNode  byte = 2
+	recordTypeRoot      byte = 3
+)
+
+// appendLog is a sequential, crash-recoverable record log. Every record is
+// stored as [4-byte length][payload][4-byte CRC32][4-byte length], the
+// trailing length duplicate allowing the log to be scanned backwards from
+// the tail without a separate index.
+type appendLog struct {
+	f      *os.File
+	offset uint64
+}
+
+func openAppendLog(dir string) (*appendLog, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, segmentFileName), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &appendLog{f: f, offset: uint64(info.Size())}, nil
+}
+
+func (a *appendLog) append(payload []byte) (recOffset uint64, err error) {
+	recOffset = a.offset
+
+	buf := make([]byte, 4+len(payload)+4+4)
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	copy(buf[4:4+len(payload)], payload)
+	binary.BigEndian.PutUint32(buf[4+len(payload):8+len(payload)], crc32.ChecksumIEEE(payload))
+	binary.BigEndian.PutUint32(buf[8+len(payload):], uint32(len(payload)))
+
+	n, err := a.f.WriteAt(buf, int64(a.offset))
+	if err != nil {
+		return 0, err
+	}
+
+	a.offset += uint64(n)
+
+	return recOffset, nil
+}
+
+func (a *appendLog) readAt(offset uint64) ([]byte, error) {
+	lenBuf := make([]byte, 4)
+	if _, err := a.f.ReadAt(lenBuf, int64(offset)); err != nil {
+		return nil, err
+	}
+
+	plen := binary.BigEndian.Uint32(lenBuf)
+
+	rec := make([]byte, plen+4)
+	if _, err := a.f.ReadAt(rec, int64(offset)+4); err != nil {
+		return nil, err
+	}
+
+	payload := rec[:plen]
+	crc := binary.BigEndian.Uint32(rec[plen:])
+
+	if crc != crc32.ChecksumIEEE(payload) {
+		return nil, ErrCorruptedData
+	}
+
+	return payload, nil
+}
+
+// writeRoot appends a new root descriptor record pointing at rootOffset,
+// atomically superseding any previous one: a reader recovering the file
+// only ever trusts the last valid root record found scanning from the tail.
+// The current bloom filter is serialized alongside it so cold reads after a
+// restart still benefit from it.
+func (a *appendLog) writeRoot(rootOffset uint64, rootTs uint64, maxSnapshotId uint64, bf *bloomFilter) error {
+	var m uint64
+	var k uint32
+	var words uint32
+	if bf != nil {
+		m = bf.m
+		k = uint32(bf.k)
+		words = uint32(len(bf.bits))
+	}
+
+	payload := make([]byte, 1+8+8+8+8+4+4+int(words)*8)
+	payload[0] = recordTypeRoot
+	binary.BigEndian.PutUint64(payload[1:9], rootOffset)
+	binary.BigEndian.PutUint64(payload[9:17], rootTs)
+	binary.BigEndian.PutUint64(payload[17:25], maxSnapshotId)
+	binary.BigEndian.PutUint64(payload[25:33], m)
+	binary.BigEndian.PutUint32(payload[33:37], k)
+	binary.BigEndian.PutUint32(payload[37:41], words)
+
+	for i := uint32(0); i < words; i++ {
+		binary.BigEndian.PutUint64(payload[41+i*8:49+i*8], bf.bits[i])
+	}
+
+	_, err := a.append(payload)
+	return err
+}
+
+func (a *appendLog) sync() error {
+	return a.f.Sync()
+}
+
+func (a *appendLog) close() error {
+	return a.f.Close()
+}
+
+// recoverAppendLog opens (or creates) the segment file under dir and, if it
+// already contains data, scans backwards from the tail for the last valid
+// root record, rebuilding the in-memory root node, maxSnapshotId and
+// lastFlushedTs from it. Trailing garbage left by a partial write is simply
+// skipped over: the scan keeps walking towards the head until a record with
+// a matching CRC32 and a recordTypeRoot marker is found.
+func recoverAppendLog(t *TBtree, dir string) (appLog *appendLog, root node, maxSnapshotId uint64, lastFlushedTs uint64, err error) {
+	appLog, err = openAppendLog(dir)
+	if err != nil {
+		return nil, nil, 0, 0, err
+	}
+
+	end := appLog.offset
+
+	for end >= 12 {
+		var trailer [4]byte
+		if _, err := appLog.f.ReadAt(trailer[:], int64(end)-4); err != nil {
+			return nil, nil, 0, 0, err
+		}
+
+		plen := uint64(binary.BigEndian.Uint32(trailer[:]))
+		recSize := uint64(12) + plen
+
+		if recSize > end {
+			end--
+			continue
+		}
+
+		recOffset := end - recSize
+
+		payload, rerr := appLog.readAt(recOffset)
+		if rerr != nil || len(payload) == 0 || payload[0] != recordTypeRoot {
+			end--
+			continue
+		}
+
+		rootOffset := binary.BigEndian.Uint64(payload[1:9])
+		rootTs := binary.BigEndian.Uint64(payload[9:17])
+		maxSnapshotId = binary.BigEndian.Uint64(payload[17:25])
+
+		if len(payload) >= 41 {
+			m := binary.BigEndian.Uint64(payload[25:33])
+			k := binary.BigEndian.Uint32(payload[33:37])
+			words := binary.BigEndian.Uint32(payload[37:41])
+
+			bits := make([]uint64, words)
+			for i := uint32(0); i < words; i++ {
+				bits[i] = binary.BigEndian.Uint64(payload[41+i*8 : 49+i*8])
+			}
+
+			t.bloom = &bloomFilter{bits: bits, m: m, k: int(k)}
+		}
+
+		rootPayload, err := appLog.readAt(rootOffset)
+		if err != nil {
+			return nil, nil, 0, 0, err
+		}
+
+		root, err = decodeNode(t, rootOffset, rootPayload)
+		if err != nil {
+			return nil, nil, 0, 0, err
+		}
+
+		return appLog, root, maxSnapshotId, rootTs, nil
+	}
+
+	return appLog, nil, 0, 0, nil
+}
+
+func encodeInnerNode(n *innerNode) []byte {
+	size := 1 + 8 + 4
+	for _, cref := range n.nodes {
+		size += 4 + len(cref.key) + 8 + 8
+	}
+
+	buf := make([]byte, size)
+	buf[0] = recordTypeInnerNode
+	binary.BigEndian.PutUint64(buf[1:9], n.cts)
+	binary.BigEndian.PutUint32(buf[9:13], uint32(len(n.nodes)))
+
+	off := 13
+	for _, cref := range n.nodes {
+		binary.BigEndian.PutUint32(buf[off:off+4], uint32(len(cref.key)))
+		off += 4
+		copy(buf[off:off+len(cref.key)], cref.key)
+		off += len(cref.key)
+		binary.BigEndian.PutUint64(buf[off:off+8], cref.cts)
+		off += 8
+		binary.BigEndian.PutUint64(buf[off:off+8], cref.offset)
+		off += 8
+	}
+
+	return buf
+}
+
+// encodeLeafNode serializes l.values, including each key's full COW history
+// (the lv.prev chain), newest version first, so that AsOfTs reads still have
+// something to walk back through after a flush+reload.
+func encodeLeafNode(l *leafNode) []byte {
+	size := 1 + 8 + 4
+	for _, lv := range l.values {
+		size += 4 + len(lv.key) + 4
+		for v := lv; v != nil; v = v.prev {
+			size += 8 + 8 + 4 + len(v.value)
+		}
+	}
+
+	buf := make([]byte, size)
+	buf[0] = recordTypeLeafNode
+	binary.BigEndian.PutUint64(buf[1:9], l.cts)
+	binary.BigEndian.PutUint32(buf[9:13], uint32(len(l.values)))
+
+	off := 13
+	for _, lv := range l.values {
+		binary.BigEndian.PutUint32(buf[off:off+4], uint32(len(lv.key)))
+		off += 4
+		copy(buf[off:off+len(lv.key)], lv.key)
+		off += len(lv.key)
+
+		vcount := 0
+		for v := lv; v != nil; v = v.prev {
+			vcount++
+		}
+		binary.BigEndian.PutUint32(buf[off:off+4], uint32(vcount))
+		off += 4
+
+		for v := lv; v != nil; v = v.prev {
+			binary.BigEndian.PutUint64(buf[off:off+8], v.ts)
+			off += 8
+			binary.BigEndian.PutUint64(buf[off:off+8], v.prevTs)
+			off += 8
+			binary.BigEndian.PutUint32(buf[off:off+4], uint32(len(v.value)))
+			off += 4
+			copy(buf[off:off+len(v.value)], v.value)
+			off += len(v.value)
+		}
+	}
+
+	return buf
+}
+
+func decodeNode(t *TBtree, offset uint64, payload []byte) (node, error) {
+	if len(payload) < 13 {
+		return nil, ErrCorruptedData
+	}
+
+	cts := binary.BigEndian.Uint64(payload[1:9])
+	count := binary.BigEndian.Uint32(payload[9:13])
+	off := 13
+
+	switch payload[0] {
+	case recordTypeInnerNode:
+		n := &innerNode{t: t, cts: cts, maxSize: t.maxNodeSize, offset: offset, flushed: true, nodes: make([]*childRef, count)}
+
+		for i := uint32(0); i < count; i++ {
+			if off+4 > len(payload) {
+				return nil, ErrCorruptedData
+			}
+			klen := int(binary.BigEndian.Uint32(payload[off : off+4]))
+			off += 4
+
+			key := make([]byte, klen)
+			copy(key, payload[off:off+klen])
+			off += klen
+
+			childCts := binary.BigEndian.Uint64(payload[off : off+8])
+			off += 8
+
+			childOffset := binary.BigEndian.Uint64(payload[off : off+8])
+			off += 8
+
+			n.nodes[i] = &childRef{key: key, cts: childCts, offset: childOffset}
+		}
+
+		n.updateSize()
+
+		return n, nil
+	case recordTypeLeafNode:
+		l := &leafNode{t: t, cts: cts, maxSize: t.maxNodeSize, offset: offset, flushed: true, values: make([]*leafValue, count)}
+
+		for i := uint32(0); i < count; i++ {
+			klen := int(binary.BigEndian.Uint32(payload[off : off+4]))
+			off += 4
+
+			key := make([]byte, klen)
+			copy(key, payload[off:off+klen])
+			off += klen
+
+			if off+4 > len(payload) {
+				return nil, ErrCorruptedData
+			}
+			vcount := binary.BigEndian.Uint32(payload[off : off+4])
+			off += 4
+
+			var head, tail *leafValue
+
+			for v := uint32(0); v < vcount; v++ {
+				ts := binary.BigEndian.Uint64(payload[off : off+8])
+				off += 8
+
+				prevTs := binary.BigEndian.Uint64(payload[off : off+8])
+				off += 8
+
+				vlen := int(binary.BigEndian.Uint32(payload[off : off+4]))
+				off += 4
+
+				value := make([]byte, vlen)
+				copy(value, payload[off:off+vlen])
+				off += vlen
+
+				lv := &leafValue{key: key, ts: ts, prevTs: prevTs, value: value}
+
+				if head == nil {
+					head = lv
+				} else {
+					tail.prev = lv
+				}
+				tail = lv
+			}
+
+			l.values[i] = head
+		}
+
+		for _, lv := range l.values {
+			l.csize += lv.size()
+		}
+
+		return l, nil
+	}
+
+	return nil, ErrCorruptedData
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2019-2020 vChain, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package tbtree
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+// Sustained inserts after a flush walk back through nodes decoded by
+// decodeNode: if decodeNode leaves maxSize at its zero value, the next
+// insert that reaches one of those nodes force-splits it down to nothing
+// and panics in maxKey(). Regression test for that.
+func TestSustainedInsertsAfterSnapshot(t *testing.T) {
+	dir, err := os.MkdirTemp("", "tbtree_file_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tree, err := NewWith(DefaultOptions().setMaxNodeSize(MinNodeSize * 2).WithPath(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	for i := 0; i < 500; i++ {
+		if err := tree.Insert([]byte(fmt.Sprintf("key-%05d", i)), []byte("v"), uint64(i+1)); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+
+	if _, err := tree.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 500; i < 1000; i++ {
+		if err := tree.Insert([]byte(fmt.Sprintf("key-%05d", i)), []byte("v"), uint64(i+1)); err != nil {
+			t.Fatalf("insert %d after snapshot: %v", i, err)
+		}
+	}
+}
+
+// Restarting a tree that flushed at least once rebuilds its root via
+// recoverAppendLog, which must not dereference t.appLog before the caller
+// has assigned it. Regression test for that.
+func TestReopenAfterFlush(t *testing.T) {
+	dir, err := os.MkdirTemp("", "tbtree_file_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tree, err := NewWith(DefaultOptions().WithPath(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tree.Insert([]byte("k1"), []byte("v1"), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	snap, err := tree.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := snap.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewWith(DefaultOptions().WithPath(dir))
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+
+	reopenedSnap, err := reopened.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopenedSnap.Close()
+
+	value, _, err := reopenedSnap.Get([]byte("k1"))
+	if err != nil {
+		t.Fatalf("get after reopen: %v", err)
+	}
+	if string(value) != "v1" {
+		t.Fatalf("got %q, want v1", value)
+	}
+}
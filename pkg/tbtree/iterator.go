@@ -0,0 +1,453 @@
+/*
+Copyright 2019-2020 vChain, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package tbtree
+
+import "bytes"
+
+// IteratorOptions configures a Snapshot.NewIterator call.
+type IteratorOptions struct {
+	// Prefix restricts iteration to keys sharing this prefix.
+	Prefix []byte
+	// SeekKey positions the iterator at the first (or, in Reverse mode,
+	// last) key greater-than-or-equal (less-than-or-equal) to it. Defaults
+	// to Prefix when unset; in Reverse mode the default is instead the last
+	// key sharing Prefix.
+	SeekKey []byte
+	// Reverse walks keys in descending order.
+	Reverse bool
+	// AsOfTs, when non-zero, returns for each key the most recent version
+	// with ts <= AsOfTs, skipping keys with no such version.
+	AsOfTs uint64
+}
+
+// iterFrame is one level of the path stack: the inner node visited and the
+// index of the child currently being walked.
+type iterFrame struct {
+	node *innerNode
+	idx  int
+}
+
+// Iterator walks a Snapshot in key order, honouring IteratorOptions. It
+// advances leaf-to-leaf by popping the deepest frame whose next sibling
+// exists and pushing back down from there, rather than restarting the
+// descent from the root on every leaf boundary.
+type Iterator struct {
+	snapshot *Snapshot
+	id       int
+	opts     IteratorOptions
+	frames   []iterFrame
+	leaf     *leafNode
+	off      int
+	valid    bool
+	closed   bool
+	curKey   []byte
+	curValue []byte
+	curTs    uint64
+}
+
+// NewIterator opens an iterator over the snapshot. A range with no matching
+// keys yields an iterator whose Valid() is false from the start, not an
+// error.
+func (s *Snapshot) NewIterator(opts IteratorOptions) (*Iterator, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return nil, ErrAlreadyClosed
+	}
+
+	it := &Iterator{snapshot: s, id: s.maxIteratorId, opts: opts}
+
+	if err := it.seekInitial(); err != nil {
+		return nil, err
+	}
+
+	s.iterators[it.id] = it
+	s.maxIteratorId++
+
+	return it, nil
+}
+
+func (it *Iterator) seekInitial() error {
+	root := it.snapshot.root
+
+	if it.opts.Reverse {
+		return it.seekInitialReverse(root)
+	}
+
+	target := it.opts.SeekKey
+	if len(target) == 0 {
+		target = it.opts.Prefix
+	}
+
+	p, leaf, off, err := root.findLeafNode(target, nil, nil, true)
+	if err != nil {
+		if err == ErrKeyNotFound {
+			it.valid = false
+			return nil
+		}
+		return err
+	}
+
+	frames, err := buildFrames(p, leaf)
+	if err != nil {
+		return err
+	}
+
+	it.frames, it.leaf, it.off = frames, leaf, off
+
+	return it.settle()
+}
+
+func (it *Iterator) seekInitialReverse(root node) error {
+	var target, neqKey []byte
+	hasBound := false
+
+	if len(it.opts.SeekKey) > 0 {
+		target = it.opts.SeekKey
+		// Inclusive bound: any key < SeekKey+0x00, i.e. <= SeekKey.
+		neqKey = append(append([]byte{}, target...), 0x00)
+		hasBound = true
+	} else if len(it.opts.Prefix) > 0 {
+		if up := prefixUpperBound(it.opts.Prefix); up != nil {
+			target = up
+			// Exclusive bound: up itself lies outside the Prefix range.
+			neqKey = up
+			hasBound = true
+		}
+	}
+
+	var p path
+	var leaf *leafNode
+	var off int
+	var err error
+
+	if !hasBound {
+		p, leaf, off, err = seekLast(root, nil)
+	} else {
+		p, leaf, off, err = root.findLeafNode(target, nil, neqKey, false)
+	}
+
+	if err != nil {
+		if err == ErrKeyNotFound {
+			it.valid = false
+			return nil
+		}
+		return err
+	}
+
+	frames, err := buildFrames(p, leaf)
+	if err != nil {
+		return err
+	}
+
+	it.frames, it.leaf, it.off = frames, leaf, off
+
+	return it.settle()
+}
+
+// settle evaluates the current (leaf, off) position, applying the Prefix and
+// AsOfTs filters and advancing past any entry that doesn't qualify.
+func (it *Iterator) settle() error {
+	for {
+		if it.leaf == nil || it.off < 0 || it.off >= len(it.leaf.values) {
+			it.valid = false
+			return nil
+		}
+
+		lv := it.leaf.values[it.off]
+
+		if len(it.opts.Prefix) > 0 && !bytes.HasPrefix(lv.key, it.opts.Prefix) {
+			it.valid = false
+			return nil
+		}
+
+		value, ts := lv.value, lv.ts
+
+		if it.opts.AsOfTs > 0 {
+			resolved, err := resolveAsOf(lv, it.opts.AsOfTs)
+			if err != nil {
+				if err := it.step(); err != nil {
+					it.valid = false
+					return nil
+				}
+				continue
+			}
+			value, ts = resolved.value, resolved.ts
+		}
+
+		it.curKey, it.curValue, it.curTs = lv.key, value, ts
+		it.valid = true
+
+		return nil
+	}
+}
+
+// step moves to the next (or, reversed, previous) leaf value without
+// applying any filter.
+func (it *Iterator) step() error {
+	if it.opts.Reverse {
+		it.off--
+		if it.off < 0 {
+			leaf, err := it.prevLeaf()
+			if err != nil {
+				return err
+			}
+			it.leaf = leaf
+			it.off = len(leaf.values) - 1
+		}
+		return nil
+	}
+
+	it.off++
+	if it.off >= len(it.leaf.values) {
+		leaf, err := it.nextLeaf()
+		if err != nil {
+			return err
+		}
+		it.leaf = leaf
+		it.off = 0
+	}
+	return nil
+}
+
+func (it *Iterator) nextLeaf() (*leafNode, error) {
+	for i := len(it.frames) - 1; i >= 0; i-- {
+		it.frames[i].idx++
+
+		if it.frames[i].idx >= len(it.frames[i].node.nodes) {
+			continue
+		}
+
+		cur, err := it.frames[i].node.nodes[it.frames[i].idx].resolve(it.frames[i].node.t)
+		if err != nil {
+			return nil, err
+		}
+
+		for j := i + 1; j < len(it.frames); j++ {
+			inner, ok := cur.(*innerNode)
+			if !ok {
+				return nil, ErrIllegalState
+			}
+
+			it.frames[j] = iterFrame{node: inner, idx: 0}
+
+			cur, err = inner.nodes[0].resolve(inner.t)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		leaf, ok := cur.(*leafNode)
+		if !ok {
+			return nil, ErrIllegalState
+		}
+
+		return leaf, nil
+	}
+
+	return nil, ErrNoDataAvailable
+}
+
+func (it *Iterator) prevLeaf() (*leafNode, error) {
+	for i := len(it.frames) - 1; i >= 0; i-- {
+		it.frames[i].idx--
+
+		if it.frames[i].idx < 0 {
+			continue
+		}
+
+		cur, err := it.frames[i].node.nodes[it.frames[i].idx].resolve(it.frames[i].node.t)
+		if err != nil {
+			return nil, err
+		}
+
+		for j := i + 1; j < len(it.frames); j++ {
+			inner, ok := cur.(*innerNode)
+			if !ok {
+				return nil, ErrIllegalState
+			}
+
+			lastIdx := len(inner.nodes) - 1
+			it.frames[j] = iterFrame{node: inner, idx: lastIdx}
+
+			cur, err = inner.nodes[lastIdx].resolve(inner.t)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		leaf, ok := cur.(*leafNode)
+		if !ok {
+			return nil, ErrIllegalState
+		}
+
+		return leaf, nil
+	}
+
+	return nil, ErrNoDataAvailable
+}
+
+// Key returns the current entry's key.
+func (it *Iterator) Key() []byte {
+	return it.curKey
+}
+
+// Value returns the current entry's value.
+func (it *Iterator) Value() []byte {
+	return it.curValue
+}
+
+// Ts returns the current entry's version ts (the version selected by
+// IteratorOptions.AsOfTs, if set).
+func (it *Iterator) Ts() uint64 {
+	return it.curTs
+}
+
+// Valid reports whether the iterator is currently positioned on an entry.
+func (it *Iterator) Valid() bool {
+	return it.valid && !it.closed
+}
+
+// Next advances the iterator. Running past the last entry is not an error:
+// Valid() becomes false.
+func (it *Iterator) Next() error {
+	if it.closed {
+		return ErrAlreadyClosed
+	}
+
+	if !it.valid {
+		return ErrNoDataAvailable
+	}
+
+	if err := it.step(); err != nil {
+		it.valid = false
+		return nil
+	}
+
+	return it.settle()
+}
+
+// Close releases the iterator, unblocking the owning snapshot's Close.
+func (it *Iterator) Close() error {
+	if it.closed {
+		return ErrAlreadyClosed
+	}
+
+	it.closed = true
+	it.snapshot.iteratorClosed(it)
+
+	return nil
+}
+
+func (s *Snapshot) iteratorClosed(it *Iterator) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.iterators, it.id)
+}
+
+// buildFrames reconstructs, for each inner node on the path returned by
+// findLeafNode, the index of the child that was taken to reach the next
+// node down (or the leaf, at the bottom of the stack).
+func buildFrames(p path, leaf *leafNode) ([]iterFrame, error) {
+	frames := make([]iterFrame, len(p))
+
+	var next node = leaf
+
+	for i := len(p) - 1; i >= 0; i-- {
+		idx, err := indexOfChild(p[i], next)
+		if err != nil {
+			return nil, err
+		}
+
+		frames[i] = iterFrame{node: p[i], idx: idx}
+		next = p[i]
+	}
+
+	return frames, nil
+}
+
+func indexOfChild(parent *innerNode, child node) (int, error) {
+	offset, flushed := nodeOffsetOf(child)
+
+	for i, cref := range parent.nodes {
+		crefNode, crefOffset := cref.snapshot()
+
+		if crefNode == child {
+			return i, nil
+		}
+		if flushed && crefNode == nil && crefOffset == offset {
+			return i, nil
+		}
+	}
+
+	return 0, ErrIllegalState
+}
+
+// seekLast descends to the rightmost leaf, used to start a Reverse iterator
+// that has no SeekKey/Prefix upper bound.
+func seekLast(n node, p path) (path, *leafNode, int, error) {
+	switch v := n.(type) {
+	case *innerNode:
+		i := len(v.nodes) - 1
+
+		child, err := v.nodes[i].resolve(v.t)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+
+		return seekLast(child, append(p, v))
+	case *leafNode:
+		if len(v.values) == 0 {
+			return nil, nil, 0, ErrKeyNotFound
+		}
+
+		return p, v, len(v.values) - 1, nil
+	}
+
+	return nil, nil, 0, ErrIllegalState
+}
+
+// prefixUpperBound returns the smallest key that is greater than every key
+// sharing prefix, or nil if prefix has no such bound (e.g. it is empty or
+// made entirely of 0xFF bytes).
+func prefixUpperBound(prefix []byte) []byte {
+	up := make([]byte, len(prefix))
+	copy(up, prefix)
+
+	for i := len(up) - 1; i >= 0; i-- {
+		if up[i] < 0xFF {
+			up[i]++
+			return up[:i+1]
+		}
+	}
+
+	return nil
+}
+
+// resolveAsOf walks a leafValue's COW history for the most recent version
+// with ts <= asOfTs.
+func resolveAsOf(lv *leafValue, asOfTs uint64) (*leafValue, error) {
+	for lv != nil {
+		if lv.ts <= asOfTs {
+			return lv, nil
+		}
+		lv = lv.prev
+	}
+
+	return nil, ErrKeyNotFound
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2019-2020 vChain, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package tbtree
+
+import "sync"
+
+// Snapshot is a read-only, point-in-time view of a TBtree. It pins the root
+// node that was current when the snapshot was taken so that Insert calls
+// happening afterwards never affect readers already using it.
+type Snapshot struct {
+	t             *TBtree
+	id            uint64
+	root          node
+	ts            uint64
+	bloom         *bloomFilter
+	readers       map[int]*Reader
+	maxReaderId   int
+	iterators     map[int]*Iterator
+	maxIteratorId int
+	closed        bool
+	mutex         sync.Mutex
+}
+
+// Ts returns the write watermark as of this snapshot: the ts of the last
+// entry inserted before the snapshot was taken.
+func (s *Snapshot) Ts() uint64 {
+	return s.ts
+}
+
+// Get looks up key as of this snapshot. A negative bloom filter hit lets the
+// lookup return ErrKeyNotFound without descending into the tree at all.
+func (s *Snapshot) Get(key []byte) (value []byte, ts uint64, err error) {
+	if s.bloom != nil && !s.bloom.mayContain(key) {
+		return nil, 0, ErrKeyNotFound
+	}
+
+	return s.root.get(key)
+}
+
+// NewReader opens a forward, key-ordered reader over the snapshot starting
+// at keyPrefix.
+func (s *Snapshot) NewReader(keyPrefix []byte) (*Reader, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return nil, ErrAlreadyClosed
+	}
+
+	path, leaf, off, err := s.root.findLeafNode(keyPrefix, nil, nil, true)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := &Reader{
+		snapshot: s,
+		id:       s.maxReaderId,
+		path:     path,
+		leaf:     leaf,
+		off:      off,
+	}
+
+	s.readers[reader.id] = reader
+	s.maxReaderId++
+
+	return reader, nil
+}
+
+func (s *Snapshot) readerClosed(r *Reader) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.readers, r.id)
+}
+
+// Close releases the snapshot, making its root node reclaimable once no
+// other snapshot pins it.
+func (s *Snapshot) Close() error {
+	s.mutex.Lock()
+
+	if s.closed {
+		s.mutex.Unlock()
+		return ErrAlreadyClosed
+	}
+
+	if len(s.readers) > 0 || len(s.iterators) > 0 {
+		s.mutex.Unlock()
+		return ErrSnapshotsNotClosed
+	}
+
+	s.closed = true
+	s.mutex.Unlock()
+
+	return s.t.snapshotClosed(s)
+}
+
+// Reader walks, in ascending key order and one leaf value at a time, the
+// leaf NewReader positioned it on. It does not continue into the following
+// leaves.
+type Reader struct {
+	snapshot *Snapshot
+	id       int
+	path     path
+	leaf     *leafNode
+	off      int
+	closed   bool
+}
+
+// Read returns the current entry and advances the reader. Past the last
+// value of the reader's leaf it returns ErrNoDataAvailable.
+func (r *Reader) Read() (key []byte, value []byte, ts uint64, err error) {
+	if r.closed {
+		return nil, nil, 0, ErrAlreadyClosed
+	}
+
+	if r.leaf == nil || r.off >= len(r.leaf.values) {
+		return nil, nil, 0, ErrNoDataAvailable
+	}
+
+	lv := r.leaf.values[r.off]
+	r.off++
+
+	return lv.key, lv.value, lv.ts, nil
+}
+
+// Close releases the reader, unblocking the owning snapshot's Close.
+func (r *Reader) Close() error {
+	if r.closed {
+		return ErrAlreadyClosed
+	}
+
+	r.closed = true
+	r.snapshot.readerClosed(r)
+
+	return nil
+}
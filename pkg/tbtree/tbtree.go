@@ -26,6 +26,8 @@ var ErrKeyNotFound = errors.New("key not found")
 var ErrIllegalState = errors.New("illegal state")
 var ErrAlreadyClosed = errors.New("already closed")
 var ErrSnapshotsNotClosed = errors.New("snapshots not closed")
+var ErrCorruptedData = errors.New("corrupted data")
+var ErrNoDataAvailable = errors.New("no data available")
 
 const MinNodeSize = 64
 const DefaultMaxNodeSize = 4096
@@ -37,10 +39,15 @@ type TBtree struct {
 	maxNodeSize             int
 	insertionCount          uint64
 	insertionCountThreshold uint64
-	// bloom filter
-	// file
-	// node manager
-	lastFlushedTs uint64
+	bloom                   *bloomFilter
+	bloomFPRate             float64
+	bloomPool               *sync.Pool
+	path                    string
+	appLog                  *appendLog
+	nodeManager             NodeManager
+	cacheBytes              int64
+	tsWatermark             uint64
+	lastFlushedTs           uint64
 	snapshots     map[uint64]*Snapshot
 	maxSnapshotId uint64
 	closed        bool
@@ -50,12 +57,17 @@ type TBtree struct {
 type Options struct {
 	maxNodeSize             int
 	insertionCountThreshold uint64
+	bloomFPRate             float64
+	path                    string
+	cacheBytes              int64
 }
 
 func DefaultOptions() *Options {
 	return &Options{
 		maxNodeSize:             DefaultMaxNodeSize,
 		insertionCountThreshold: DefaultInsertionCountThreshold,
+		bloomFPRate:             DefaultBloomFPRate,
+		cacheBytes:              DefaultCacheBytes,
 	}
 }
 
@@ -69,6 +81,27 @@ func (opt *Options) setInsertionCountThreshold(insertionCountThreshold uint64) *
 	return opt
 }
 
+// WithPath enables durable persistence: snapshots are appended to the single
+// segment file under dir instead of being kept in memory only.
+func (opt *Options) WithPath(dir string) *Options {
+	opt.path = dir
+	return opt
+}
+
+// WithBloomFPRate sets the false-positive rate used to size each snapshot's
+// bloom filter.
+func (opt *Options) WithBloomFPRate(bloomFPRate float64) *Options {
+	opt.bloomFPRate = bloomFPRate
+	return opt
+}
+
+// WithCacheBytes sets the node manager's cache budget, in bytes, for nodes
+// loaded back from the append-only log.
+func (opt *Options) WithCacheBytes(cacheBytes int64) *Options {
+	opt.cacheBytes = cacheBytes
+	return opt
+}
+
 type path []*innerNode
 
 type node interface {
@@ -80,25 +113,33 @@ type node interface {
 }
 
 type innerNode struct {
+	t        *TBtree
 	prevNode node
 	nodes    []*childRef
 	cts      uint64
 	csize    int
 	maxSize  int
-	offset   uint64
+
+	// offset and flushed are only ever set once, by flush, but the node may
+	// still be shared (unchanged) with an older, still-open snapshot whose
+	// readers walk it without taking t.rwmutex, so flushMu guards them.
+	flushMu sync.Mutex
+	offset  uint64
+	flushed bool
 }
 
 type leafNode struct {
+	t        *TBtree
 	prevNode node
 	values   []*leafValue
 	cts      uint64
 	csize    int
 	maxSize  int
-	offset   uint64
-}
 
-type nodeRef struct {
-	offset uint64
+	// See innerNode.flushMu.
+	flushMu sync.Mutex
+	offset  uint64
+	flushed bool
 }
 
 type leafValue struct {
@@ -106,12 +147,23 @@ type leafValue struct {
 	ts     uint64
 	prevTs uint64
 	value  []byte
+	// prev links to the leafValue this one superseded, letting a COW chain
+	// be walked back in time for as-of reads. encodeLeafNode/decodeNode
+	// persist the full chain, so it survives a flush+reload too.
+	prev *leafValue
 }
 
 type childRef struct {
-	key  []byte
-	cts  uint64
-	node node
+	key []byte
+	cts uint64
+
+	// offset and node are read by cRef.resolve with no lock held (the COW
+	// read path is deliberately lock-free, see Insert's doc comment) while
+	// flush concurrently writes them back in as the child is made durable,
+	// so both are guarded by mu rather than being plain fields.
+	mu     sync.Mutex
+	offset uint64
+	node   node
 }
 
 func New() (*TBtree, error) {
@@ -126,13 +178,52 @@ func NewWith(opt *Options) (*TBtree, error) {
 	tbtree := &TBtree{
 		maxNodeSize:             opt.maxNodeSize,
 		insertionCountThreshold: opt.insertionCountThreshold,
-		root:                    &leafNode{maxSize: opt.maxNodeSize},
+		bloomFPRate:             opt.bloomFPRate,
+		bloomPool:               &sync.Pool{},
+		path:                    opt.path,
+		cacheBytes:              opt.cacheBytes,
 		snapshots:               make(map[uint64]*Snapshot),
 	}
 
+	if opt.path != "" {
+		tbtree.nodeManager = newLRUNodeManager(opt.cacheBytes, tbtree.loadNode)
+
+		appLog, root, maxSnapshotId, lastFlushedTs, err := recoverAppendLog(tbtree, opt.path)
+		if err != nil {
+			return nil, err
+		}
+
+		tbtree.appLog = appLog
+		tbtree.maxSnapshotId = maxSnapshotId
+		tbtree.lastFlushedTs = lastFlushedTs
+		tbtree.tsWatermark = lastFlushedTs
+
+		if root != nil {
+			tbtree.root = root
+		}
+	}
+
+	if tbtree.root == nil {
+		tbtree.root = &leafNode{t: tbtree, maxSize: opt.maxNodeSize}
+	}
+
+	if tbtree.bloom == nil {
+		tbtree.bloom = newBloomFilter(tbtree.insertionCountThreshold, tbtree.bloomFPRate, tbtree.bloomPool)
+	}
+
 	return tbtree, nil
 }
 
+// CacheStats reports the node manager's cache usage, or the zero value when
+// the tree holds everything in memory (no Options.WithPath configured).
+func (t *TBtree) CacheStats() CacheStats {
+	if t.nodeManager == nil {
+		return CacheStats{}
+	}
+
+	return t.nodeManager.Stats()
+}
+
 func (t *TBtree) Close() error {
 	t.rwmutex.Lock()
 	defer t.rwmutex.Unlock()
@@ -146,11 +237,21 @@ func (t *TBtree) Close() error {
 	}
 
 	if t.insertionCount > 0 {
-		_, err := t.Snapshot()
-		if err != nil {
+		// Inlined Snapshot(): we already hold the write lock here, and
+		// Snapshot() would try to take it again.
+		if t.appLog != nil {
+			if err := t.flush(); err != nil {
+				return err
+			}
+		}
+
+		t.newSnapshot()
+	}
+
+	if t.appLog != nil {
+		if err := t.appLog.close(); err != nil {
 			return err
 		}
-		// TODO: lastest snapshot must be flushed
 	}
 
 	t.closed = true
@@ -158,64 +259,252 @@ func (t *TBtree) Close() error {
 	return nil
 }
 
+// Insert builds the new, copy-on-write root entirely outside of any lock –
+// the tree's existing nodes are never mutated, so reading the current root
+// and walking down from it is safe for any number of concurrent readers.
+// The rwmutex is only acquired afterwards, just long enough to verify the
+// root hasn't moved and swap it in; if another writer raced ahead in the
+// meantime, the (cheap) CAS check fails and insertAt is retried against the
+// fresh root instead of silently clobbering it.
 func (t *TBtree) Insert(key []byte, value []byte, ts uint64) error {
+	if key == nil {
+		return ErrIllegalArgument
+	}
+
+	for {
+		t.rwmutex.RLock()
+		closed := t.closed
+		root := t.root
+		t.rwmutex.RUnlock()
+
+		if closed {
+			return ErrAlreadyClosed
+		}
+
+		if root.ts() >= ts {
+			return ErrIllegalArgument
+		}
+
+		n1, n2, err := root.insertAt(key, value, ts)
+		if err != nil {
+			return err
+		}
+
+		var newRoot node = n1
+
+		if n2 != nil {
+			ns := make([]*childRef, 2)
+			inner := &innerNode{t: t, prevNode: root, maxSize: t.maxNodeSize, nodes: ns, cts: ts}
+
+			ns[0] = &childRef{key: n1.maxKey(), cts: n1.ts(), node: n1}
+			ns[1] = &childRef{key: n2.maxKey(), cts: n2.ts(), node: n2}
+
+			inner.updateSize()
+
+			newRoot = inner
+		}
+
+		t.rwmutex.Lock()
+
+		if t.closed {
+			t.rwmutex.Unlock()
+			return ErrAlreadyClosed
+		}
+
+		if t.root != root {
+			// Another writer swapped the root while we were building ours;
+			// retry against the now-current root instead of overwriting it.
+			t.rwmutex.Unlock()
+			continue
+		}
+
+		t.root = newRoot
+		t.insertionCount++
+		t.bloom.add(key)
+		t.tsWatermark = ts
+
+		t.rwmutex.Unlock()
+
+		return nil
+	}
+}
+
+func (t *TBtree) Snapshot() (*Snapshot, error) {
 	t.rwmutex.Lock()
 	defer t.rwmutex.Unlock()
 
 	if t.closed {
-		return ErrAlreadyClosed
+		return nil, ErrAlreadyClosed
 	}
 
-	if key == nil || t.root.ts() >= ts {
-		return ErrIllegalArgument
+	if len(t.snapshots) > 0 && t.insertionCount <= t.insertionCountThreshold {
+		return t.snapshots[t.maxSnapshotId], nil
 	}
 
-	n1, n2, err := t.root.insertAt(key, value, ts)
+	if t.appLog != nil {
+		if err := t.flush(); err != nil {
+			return nil, err
+		}
+	}
+
+	return t.newSnapshot(), nil
+}
+
+// flush writes any dirty inner/leaf nodes reachable from the current root
+// into the append-only segment file and then rewrites the root descriptor
+// record at the file tail, making the tree durable up to t.root.
+func (t *TBtree) flush() error {
+	rootOffset, err := t.flushNode(t.root)
 	if err != nil {
 		return err
 	}
 
-	t.insertionCount++
+	if err := t.appLog.writeRoot(rootOffset, t.root.ts(), t.maxSnapshotId, t.bloom); err != nil {
+		return err
+	}
 
-	if n2 == nil {
-		t.root = n1
-		return nil
+	t.lastFlushedTs = t.root.ts()
+
+	return nil
+}
+
+func (t *TBtree) flushNode(n node) (uint64, error) {
+	switch v := n.(type) {
+	case *innerNode:
+		return t.flushInnerNode(v)
+	case *leafNode:
+		return t.flushLeafNode(v)
 	}
+	return 0, ErrIllegalState
+}
 
-	ns := make([]*childRef, 2)
-	newRoot := &innerNode{prevNode: t.root, maxSize: t.maxNodeSize, nodes: ns, cts: ts}
+func (t *TBtree) flushInnerNode(n *innerNode) (uint64, error) {
+	n.flushMu.Lock()
+	if n.flushed {
+		offset := n.offset
+		n.flushMu.Unlock()
+		return offset, nil
+	}
+	n.flushMu.Unlock()
 
-	ns[0] = &childRef{key: n1.maxKey(), cts: n1.ts(), node: n1}
-	ns[1] = &childRef{key: n2.maxKey(), cts: n2.ts(), node: n2}
+	for _, cref := range n.nodes {
+		child, err := cref.resolve(t)
+		if err != nil {
+			return 0, err
+		}
 
-	newRoot.updateSize()
+		childOffset, err := t.flushNode(child)
+		if err != nil {
+			return 0, err
+		}
 
-	t.root = newRoot
+		cref.mu.Lock()
+		cref.offset = childOffset
+		if t.nodeManager != nil {
+			// Drop the strong reference now that the child is durable: future
+			// reads fault it back in through the node manager, bounding how
+			// much of the tree stays resident in memory.
+			cref.node = nil
+		}
+		cref.mu.Unlock()
+	}
 
-	return nil
+	offset, err := t.appLog.append(encodeInnerNode(n))
+	if err != nil {
+		return 0, err
+	}
+
+	n.flushMu.Lock()
+	n.offset = offset
+	n.flushed = true
+	n.flushMu.Unlock()
+
+	return offset, nil
 }
 
-func (t *TBtree) Snapshot() (*Snapshot, error) {
-	t.rwmutex.Lock()
-	defer t.rwmutex.Unlock()
+func (t *TBtree) flushLeafNode(l *leafNode) (uint64, error) {
+	l.flushMu.Lock()
+	if l.flushed {
+		offset := l.offset
+		l.flushMu.Unlock()
+		return offset, nil
+	}
+	l.flushMu.Unlock()
 
-	if t.closed {
-		return nil, ErrAlreadyClosed
+	offset, err := t.appLog.append(encodeLeafNode(l))
+	if err != nil {
+		return 0, err
 	}
 
-	if len(t.snapshots) > 0 && t.insertionCount <= t.insertionCountThreshold {
-		return t.snapshots[t.maxSnapshotId], nil
+	l.flushMu.Lock()
+	l.offset = offset
+	l.flushed = true
+	l.flushMu.Unlock()
+
+	return offset, nil
+}
+
+// loadNode reads and decodes the node record stored at offset. Inner nodes
+// are decoded shallowly: their children are left unresolved (node == nil,
+// offset pointing back into the log) and are faulted in on demand by
+// childRef.resolve.
+func (t *TBtree) loadNode(offset uint64) (node, error) {
+	payload, err := t.appLog.readAt(offset)
+	if err != nil {
+		return nil, err
 	}
 
-	return t.newSnapshot(), nil
+	return decodeNode(t, offset, payload)
+}
+
+// snapshot returns cref's node and offset as of one consistent instant,
+// for callers (e.g. indexOfChild) that only want to read them, not resolve.
+func (cref *childRef) snapshot() (node, uint64) {
+	cref.mu.Lock()
+	defer cref.mu.Unlock()
+
+	return cref.node, cref.offset
+}
+
+func (cref *childRef) resolve(t *TBtree) (node, error) {
+	cref.mu.Lock()
+	n := cref.node
+	offset := cref.offset
+	cref.mu.Unlock()
+
+	if n != nil {
+		return n, nil
+	}
+
+	if t.nodeManager != nil {
+		return t.nodeManager.Load(offset)
+	}
+
+	if t.appLog == nil {
+		return nil, ErrIllegalState
+	}
+
+	n, err := t.loadNode(offset)
+	if err != nil {
+		return nil, err
+	}
+
+	cref.mu.Lock()
+	cref.node = n
+	cref.mu.Unlock()
+
+	return n, nil
 }
 
 func (t *TBtree) newSnapshot() *Snapshot {
 	snapshot := &Snapshot{
-		t:       t,
-		id:      t.maxSnapshotId,
-		root:    t.root,
-		readers: make(map[int]*Reader),
+		t:         t,
+		id:        t.maxSnapshotId,
+		root:      t.root,
+		ts:        t.tsWatermark,
+		bloom:     t.bloom,
+		readers:   make(map[int]*Reader),
+		iterators: make(map[int]*Iterator),
 	}
 
 	t.snapshots[snapshot.id] = snapshot
@@ -223,6 +512,17 @@ func (t *TBtree) newSnapshot() *Snapshot {
 	t.maxSnapshotId++
 	t.insertionCount = 0
 
+	// The new filter must still answer for every key reachable from the new
+	// root, not just the ones inserted since the last snapshot, so carry the
+	// previous filter's bits forward rather than starting empty.
+	prevBloom := t.bloom
+	t.bloom = newBloomFilter(t.insertionCountThreshold, t.bloomFPRate, t.bloomPool)
+	t.bloom.union(prevBloom)
+
+	if t.nodeManager != nil {
+		t.nodeManager.Pin(snapshot.root)
+	}
+
 	return snapshot
 }
 
@@ -236,7 +536,11 @@ func (t *TBtree) snapshotClosed(snapshot *Snapshot) error {
 
 	delete(t.snapshots, snapshot.id)
 
-	// recycle allocations : node manager
+	snapshot.bloom.recycle(t.bloomPool)
+
+	if t.nodeManager != nil {
+		t.nodeManager.Unpin(snapshot.root)
+	}
 
 	return nil
 }
@@ -246,15 +550,19 @@ func (n *innerNode) insertAt(key []byte, value []byte, ts uint64) (n1 node, n2 n
 
 	cRef := n.nodes[insertAt]
 
-	// TODO: jeroiraz it's possible that childRef is not loaded into main mem yet
+	child, err := cRef.resolve(n.t)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	c1, c2, err := cRef.node.insertAt(key, value, ts)
+	c1, c2, err := child.insertAt(key, value, ts)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	if c2 == nil {
 		newNode := &innerNode{
+			t:        n.t,
 			prevNode: n,
 			maxSize:  n.maxSize,
 			nodes:    make([]*childRef, len(n.nodes)),
@@ -275,6 +583,7 @@ func (n *innerNode) insertAt(key []byte, value []byte, ts uint64) (n1 node, n2 n
 	}
 
 	newNode := &innerNode{
+		t:        n.t,
 		prevNode: n,
 		maxSize:  n.maxSize,
 		nodes:    make([]*childRef, len(n.nodes)+1),
@@ -304,14 +613,23 @@ func (n *innerNode) get(key []byte) (value []byte, ts uint64, err error) {
 		return nil, 0, ErrKeyNotFound
 	}
 
-	return n.nodes[i].node.get(key)
+	child, err := n.nodes[i].resolve(n.t)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return child.get(key)
 }
 
 func (n *innerNode) findLeafNode(keyPrefix []byte, path path, neqKey []byte, ascOrder bool) (path, *leafNode, int, error) {
 	if ascOrder || neqKey == nil {
 		for i := 0; i < len(n.nodes); i++ {
 			if bytes.Compare(keyPrefix, n.nodes[i].key) < 1 && bytes.Compare(n.nodes[i].key, neqKey) == 1 {
-				return n.nodes[i].node.findLeafNode(keyPrefix, append(path, n), neqKey, ascOrder)
+				child, err := n.nodes[i].resolve(n.t)
+				if err != nil {
+					return nil, nil, 0, err
+				}
+				return child.findLeafNode(keyPrefix, append(path, n), neqKey, ascOrder)
 			}
 		}
 
@@ -319,12 +637,20 @@ func (n *innerNode) findLeafNode(keyPrefix []byte, path path, neqKey []byte, asc
 			return nil, nil, 0, ErrKeyNotFound
 		}
 
-		return n.nodes[len(n.nodes)-1].node.findLeafNode(keyPrefix, append(path, n), neqKey, ascOrder)
+		child, err := n.nodes[len(n.nodes)-1].resolve(n.t)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		return child.findLeafNode(keyPrefix, append(path, n), neqKey, ascOrder)
 	}
 
 	for i := len(n.nodes); i > 0; i-- {
 		if bytes.Compare(n.nodes[i-1].key, keyPrefix) < 1 && bytes.Compare(n.nodes[i-1].key, neqKey) < 0 {
-			return n.nodes[i-1].node.findLeafNode(keyPrefix, append(path, n), neqKey, ascOrder)
+			child, err := n.nodes[i-1].resolve(n.t)
+			if err != nil {
+				return nil, nil, 0, err
+			}
+			return child.findLeafNode(keyPrefix, append(path, n), neqKey, ascOrder)
 		}
 	}
 
@@ -364,6 +690,7 @@ func (n *innerNode) split() (node, error) {
 	splitIndex, splitSize := n.splitInfo()
 
 	newNode := &innerNode{
+		t:       n.t,
 		maxSize: n.maxSize,
 		nodes:   n.nodes[splitIndex:],
 		csize:   n.csize - splitSize,
@@ -405,6 +732,7 @@ func (l *leafNode) insertAt(key []byte, value []byte, ts uint64) (n1 node, n2 no
 
 	if found {
 		newLeaf := &leafNode{
+			t:        l.t,
 			prevNode: l,
 			maxSize:  l.maxSize,
 			cts:      ts,
@@ -419,6 +747,7 @@ func (l *leafNode) insertAt(key []byte, value []byte, ts uint64) (n1 node, n2 no
 			ts:     ts,
 			prevTs: l.values[i].ts,
 			value:  value,
+			prev:   l.values[i],
 		}
 
 		if i+1 < len(newLeaf.values) {
@@ -436,6 +765,7 @@ func (l *leafNode) insertAt(key []byte, value []byte, ts uint64) (n1 node, n2 no
 	}
 
 	newLeaf := &leafNode{
+		t:        l.t,
 		prevNode: l,
 		maxSize:  l.maxSize,
 		cts:      ts,
@@ -521,6 +851,7 @@ func (l *leafNode) split() (node, error) {
 	splitIndex, splitSize := l.splitInfo()
 
 	newLeaf := &leafNode{
+		t:       l.t,
 		maxSize: l.maxSize,
 		values:  l.values[splitIndex:],
 		csize:   l.csize - splitSize,
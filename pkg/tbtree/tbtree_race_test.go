@@ -0,0 +1,85 @@
+/*
+Copyright 2019-2020 vChain, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package tbtree
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// flush mutates a (possibly shared, still-referenced-by-an-older-snapshot)
+// node's offset/flushed/childRef fields, while the lock-free COW read path
+// (childRef.resolve, nodeOffsetOf, indexOfChild) reads those same fields
+// without taking t.rwmutex. Run under -race: a writer keeps inserting and
+// snapshotting (forcing real flushes) while several readers iterate an
+// older, still-open snapshot concurrently.
+func TestConcurrentFlushAndOlderSnapshotReaders(t *testing.T) {
+	tree, err := NewWith(DefaultOptions().setMaxNodeSize(MinNodeSize * 2).setInsertionCountThreshold(1).WithPath(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	for i := 0; i < 50; i++ {
+		if err := tree.Insert([]byte(fmt.Sprintf("key-%05d", i)), []byte("v"), uint64(i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	oldSnap, err := tree.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer oldSnap.Close()
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 50; i < 550; i++ {
+			if err := tree.Insert([]byte(fmt.Sprintf("key-%05d", i)), []byte("v"), uint64(i+1)); err != nil {
+				return
+			}
+			if _, err := tree.Snapshot(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				it, err := oldSnap.NewIterator(IteratorOptions{})
+				if err != nil {
+					return
+				}
+				for it.Valid() {
+					_ = it.Key()
+					if it.Next() != nil {
+						break
+					}
+				}
+				it.Close()
+			}
+		}()
+	}
+
+	wg.Wait()
+}